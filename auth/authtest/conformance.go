@@ -0,0 +1,124 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package authtest holds a shared conformance suite every auth.KV
+// implementation must pass, so memauth, boltauth, pgauth, and redisauth are
+// all exercised against the same behavioral contract instead of each
+// backend growing its own, possibly divergent, notion of what Put/Get/
+// Delete/Invalidate mean.
+package authtest
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/gateway-mt/auth"
+)
+
+// RunKVConformance runs the contract every auth.KV implementation must
+// satisfy against the KV newKV returns. newKV is called once per subtest so
+// backends that need a fresh instance per case (e.g. a temp-file-backed
+// bolt.DB) can set one up and register its teardown with t.Cleanup.
+func RunKVConformance(t *testing.T, newKV func(t *testing.T) auth.KV) {
+	ctx := context.Background()
+
+	t.Run("get of a missing key returns nil", func(t *testing.T) {
+		kv := newKV(t)
+
+		record, err := kv.Get(ctx, randomKeyHash(t))
+		require.NoError(t, err)
+		require.Nil(t, record)
+	})
+
+	t.Run("put then get round trips the record", func(t *testing.T) {
+		kv := newKV(t)
+		keyHash := randomKeyHash(t)
+		want := randomRecord()
+
+		require.NoError(t, kv.Put(ctx, keyHash, want))
+
+		got, err := kv.Get(ctx, keyHash)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	})
+
+	t.Run("put of an existing key fails", func(t *testing.T) {
+		kv := newKV(t)
+		keyHash := randomKeyHash(t)
+
+		require.NoError(t, kv.Put(ctx, keyHash, randomRecord()))
+		require.Error(t, kv.Put(ctx, keyHash, randomRecord()))
+	})
+
+	t.Run("delete of a missing key is not an error", func(t *testing.T) {
+		kv := newKV(t)
+
+		require.NoError(t, kv.Delete(ctx, randomKeyHash(t)))
+	})
+
+	t.Run("delete then get returns nil", func(t *testing.T) {
+		kv := newKV(t)
+		keyHash := randomKeyHash(t)
+
+		require.NoError(t, kv.Put(ctx, keyHash, randomRecord()))
+		require.NoError(t, kv.Delete(ctx, keyHash))
+
+		record, err := kv.Get(ctx, keyHash)
+		require.NoError(t, err)
+		require.Nil(t, record)
+	})
+
+	t.Run("invalidate then get returns Invalid", func(t *testing.T) {
+		kv := newKV(t)
+		keyHash := randomKeyHash(t)
+
+		require.NoError(t, kv.Put(ctx, keyHash, randomRecord()))
+		require.NoError(t, kv.Invalidate(ctx, keyHash, "test invalidation"))
+
+		_, err := kv.Get(ctx, keyHash)
+		require.True(t, auth.Invalid.Has(err))
+	})
+
+	t.Run("invalidate of a missing key is not an error", func(t *testing.T) {
+		kv := newKV(t)
+
+		require.NoError(t, kv.Invalidate(ctx, randomKeyHash(t), "reason"))
+	})
+
+	t.Run("invalidate does not overwrite an existing reason", func(t *testing.T) {
+		kv := newKV(t)
+		keyHash := randomKeyHash(t)
+
+		require.NoError(t, kv.Put(ctx, keyHash, randomRecord()))
+		require.NoError(t, kv.Invalidate(ctx, keyHash, "first reason"))
+		require.NoError(t, kv.Invalidate(ctx, keyHash, "second reason"))
+
+		_, err := kv.Get(ctx, keyHash)
+		require.EqualError(t, err, auth.Invalid.New("%s", "first reason").Error())
+	})
+
+	t.Run("ping succeeds", func(t *testing.T) {
+		kv := newKV(t)
+
+		require.NoError(t, kv.Ping(ctx))
+	})
+}
+
+func randomKeyHash(t *testing.T) auth.KeyHash {
+	var key auth.EncryptionKey
+	_, err := rand.Read(key[:])
+	require.NoError(t, err)
+	return key.Hash()
+}
+
+func randomRecord() *auth.Record {
+	return &auth.Record{
+		SatelliteAddress:     "satellite.test:7777",
+		MacaroonHead:         []byte("macaroon-head"),
+		EncryptedSecretKey:   []byte("encrypted-secret-key"),
+		EncryptedAccessGrant: []byte("encrypted-access-grant"),
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package boltauth implements auth.KV backed by a single BoltDB file, for
+// single-node deployments that don't need a separate database process.
+package boltauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	bolt "go.etcd.io/bbolt"
+
+	"storj.io/gateway-mt/auth"
+)
+
+var mon = monkit.Package()
+
+// Error is the class of errors returned by this package.
+var Error = errs.Class("boltauth")
+
+var (
+	recordsBucket = []byte("records")
+	invalidBucket = []byte("invalid")
+)
+
+// KV is a key/value store backed by a single BoltDB file.
+type KV struct {
+	db *bolt.DB
+}
+
+// Open opens (creating if necessary) the BoltDB file at path and returns a
+// KV backed by it.
+func Open(path string) (*KV, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(recordsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(invalidBucket)
+		return err
+	})
+	if err != nil {
+		return nil, Error.Wrap(errs.Combine(err, db.Close()))
+	}
+
+	return &KV{db: db}, nil
+}
+
+// Close closes the underlying BoltDB file.
+func (kv *KV) Close() error {
+	return Error.Wrap(kv.db.Close())
+}
+
+// Put stores the record in the key/value store.
+// It is an error if the key already exists.
+func (kv *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(record); err != nil {
+		return Error.Wrap(err)
+	}
+
+	return Error.Wrap(kv.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(recordsBucket)
+		if b.Get(keyHash[:]) != nil {
+			return errs.New("record already exists")
+		}
+		return b.Put(keyHash[:], buf.Bytes())
+	}))
+}
+
+// Get retrieves the record from the key/value store.
+// It returns nil if the key does not exist.
+func (kv *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = kv.db.View(func(tx *bolt.Tx) error {
+		if reason := tx.Bucket(invalidBucket).Get(keyHash[:]); reason != nil {
+			return auth.Invalid.New("%s", string(reason))
+		}
+
+		data := tx.Bucket(recordsBucket).Get(keyHash[:])
+		if data == nil {
+			return nil
+		}
+
+		record = &auth.Record{}
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(record)
+	})
+	if err != nil {
+		if auth.Invalid.Has(err) {
+			return nil, err
+		}
+		return nil, Error.Wrap(err)
+	}
+	return record, nil
+}
+
+// Delete removes the record from the key/value store.
+// It is not an error if the key does not exist.
+func (kv *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return Error.Wrap(kv.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(recordsBucket).Delete(keyHash[:]); err != nil {
+			return err
+		}
+		return tx.Bucket(invalidBucket).Delete(keyHash[:])
+	}))
+}
+
+// Invalidate causes the record to become invalid.
+// It is not an error if the key does not exist.
+// It does not update the invalid reason if the record is already invalid.
+func (kv *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return Error.Wrap(kv.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(invalidBucket)
+		if b.Get(keyHash[:]) != nil {
+			return nil
+		}
+		return b.Put(keyHash[:], []byte(reason))
+	}))
+}
+
+// Ping attempts to do a database roundtrip and returns an error if it can't.
+func (kv *KV) Ping(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return Error.Wrap(kv.db.View(func(tx *bolt.Tx) error { return nil }))
+}
@@ -0,0 +1,24 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package boltauth_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/gateway-mt/auth"
+	"storj.io/gateway-mt/auth/authtest"
+	"storj.io/gateway-mt/auth/boltauth"
+)
+
+func TestKV(t *testing.T) {
+	authtest.RunKVConformance(t, func(t *testing.T) auth.KV {
+		kv, err := boltauth.Open(filepath.Join(t.TempDir(), "auth.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = kv.Close() })
+		return kv
+	})
+}
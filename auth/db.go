@@ -5,11 +5,15 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 
 	"github.com/zeebo/blake3"
 	"github.com/zeebo/errs"
+	"golang.org/x/crypto/chacha20poly1305"
 
 	"storj.io/uplink"
+	"storj.io/uplink/private/access"
 )
 
 // NotFound is returned when a record is not found.
@@ -23,6 +27,17 @@ func (k EncryptionKey) Hash() KeyHash {
 	return KeyHash(blake3.Sum256(k[:]))
 }
 
+// dataKey derives the symmetric key used to seal a record's ciphertext from
+// the EncryptionKey. Using a distinct BLAKE3 derive-key context from Hash
+// keeps the index (key hash) and the encryption key from sharing key
+// material, even though both are derived from the same EncryptionKey.
+func (k EncryptionKey) dataKey() (out [32]byte) {
+	h := blake3.NewDeriveKey("storj.io/gateway-mt/auth record data key")
+	_, _ = h.Write(k[:])
+	_, _ = h.Digest().Read(out[:])
+	return out
+}
+
 // Database wraps a key/value store and uses it to store encrypted accesses and secrets.
 type Database struct {
 	kv KV
@@ -34,48 +49,123 @@ func NewDatabase(kv KV) *Database {
 }
 
 // Put encrypts the access with the key and stores it in a key/value store under the
-// hash of the encryption key.
-func (db *Database) Put(ctx context.Context, key EncryptionKey, access *uplink.Access) (err error) {
+// hash of the encryption key. It returns a freshly generated S3-style secret
+// key, which is stored encrypted alongside the access and returned again by
+// Get for the same EncryptionKey.
+func (db *Database) Put(ctx context.Context, key EncryptionKey, grant *uplink.Access) (secretKey []byte, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	serialized, err := access.Serialize()
+	serialized, err := grant.Serialize()
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+
+	secretKey, err = generateSecretKey()
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+
+	encryptedAccessGrant, err := key.seal([]byte(serialized))
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+
+	encryptedSecretKey, err := key.seal(secretKey)
 	if err != nil {
-		return errs.Wrap(err)
+		return nil, errs.Wrap(err)
+	}
+
+	apiKey, err := access.APIKey(grant)
+	if err != nil {
+		return nil, errs.Wrap(err)
 	}
 
 	record := &Record{
-		SatelliteAddress:     "TODO",             // TODO: extend something to read this
-		MacaroonHead:         []byte("TODO"),     // TODO: extend something to read this
-		EncryptedSecretKey:   []byte("TODO"),     // TODO: generate and encrypt this
-		EncryptedAccessGrant: []byte(serialized), // TODO: encrypt this
+		SatelliteAddress:     grant.SatelliteAddress(),
+		MacaroonHead:         apiKey.Head(),
+		EncryptedSecretKey:   encryptedSecretKey,
+		EncryptedAccessGrant: encryptedAccessGrant,
 	}
 
 	if err := db.kv.Put(ctx, key.Hash(), record); err != nil {
-		return errs.Wrap(err)
+		return nil, errs.Wrap(err)
 	}
 
-	return nil
+	return secretKey, nil
 }
 
 // Get retreives an access and secret key from the key/value store, looked up by the
 // hash of the key and decrypted.
-func (db *Database) Get(ctx context.Context, key EncryptionKey) (access *uplink.Access, secretKey []byte, err error) {
+func (db *Database) Get(ctx context.Context, key EncryptionKey) (grant *uplink.Access, secretKey []byte, err error) {
 	defer mon.Task()(&ctx)(&err)
 
 	record, err := db.kv.Get(ctx, key.Hash())
 	if err != nil {
-		return access, nil, errs.Wrap(err)
+		return nil, nil, errs.Wrap(err)
 	} else if record == nil {
 		return nil, nil, NotFound.New("key hash: %x", key.Hash())
 	}
 
-	secretKey = record.EncryptedSecretKey             // TODO: decrypt this
-	serialized := string(record.EncryptedAccessGrant) // TODO: decrypt this
+	secretKey, err = key.open(record.EncryptedSecretKey)
+	if err != nil {
+		return nil, nil, Invalid.Wrap(err)
+	}
+
+	serialized, err := key.open(record.EncryptedAccessGrant)
+	if err != nil {
+		return nil, nil, Invalid.Wrap(err)
+	}
 
-	access, err = uplink.ParseAccess(serialized)
+	grant, err = uplink.ParseAccess(string(serialized))
 	if err != nil {
 		return nil, nil, errs.Wrap(err)
 	}
 
-	return access, secretKey, nil
-}
\ No newline at end of file
+	return grant, secretKey, nil
+}
+
+// seal encrypts plaintext with the per-record key derived from k, prefixing
+// the returned ciphertext with the random nonce it was sealed under.
+func (k EncryptionKey) seal(plaintext []byte) ([]byte, error) {
+	dataKey := k.dataKey()
+
+	aead, err := chacha20poly1305.NewX(dataKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open reverses seal, returning an error if the ciphertext does not
+// authenticate under k, for example because it was tampered with or sealed
+// under a different EncryptionKey.
+func (k EncryptionKey) open(ciphertext []byte) ([]byte, error) {
+	dataKey := k.dataKey()
+
+	aead, err := chacha20poly1305.NewX(dataKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errs.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// generateSecretKey returns a fresh random S3-style secret key.
+func generateSecretKey() ([]byte, error) {
+	var raw [24]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return nil, err
+	}
+	return []byte(base64.RawURLEncoding.EncodeToString(raw[:])), nil
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/gateway-mt/auth/memauth"
+)
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	var key EncryptionKey
+	_, err := rand.Read(key[:])
+	require.NoError(t, err)
+
+	for _, plaintext := range [][]byte{
+		nil,
+		[]byte(""),
+		[]byte("a"),
+		[]byte("a serialized access grant, more or less"),
+		make([]byte, 10000),
+	} {
+		sealed, err := key.seal(plaintext)
+		require.NoError(t, err)
+
+		opened, err := key.open(sealed)
+		require.NoError(t, err)
+		// bytes.Equal, not require.Equal: open returns nil for a
+		// zero-length plaintext regardless of whether it was sealed as
+		// nil or an empty (non-nil) slice.
+		require.True(t, bytes.Equal(plaintext, opened))
+	}
+}
+
+func TestSealIsNotDeterministic(t *testing.T) {
+	var key EncryptionKey
+	_, err := rand.Read(key[:])
+	require.NoError(t, err)
+
+	plaintext := []byte("same plaintext every time")
+
+	first, err := key.seal(plaintext)
+	require.NoError(t, err)
+	second, err := key.seal(plaintext)
+	require.NoError(t, err)
+
+	require.NotEqual(t, first, second, "seal must use a fresh nonce every call")
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	var key EncryptionKey
+	_, err := rand.Read(key[:])
+	require.NoError(t, err)
+
+	sealed, err := key.seal([]byte("don't tamper with me"))
+	require.NoError(t, err)
+
+	for i := range sealed {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 0xff
+
+		_, err := key.open(tampered)
+		require.Error(t, err, "flipping byte %d of the sealed ciphertext must be detected", i)
+	}
+}
+
+func TestOpenRejectsWrongKey(t *testing.T) {
+	var key, otherKey EncryptionKey
+	_, err := rand.Read(key[:])
+	require.NoError(t, err)
+	_, err = rand.Read(otherKey[:])
+	require.NoError(t, err)
+
+	sealed, err := key.seal([]byte("sealed under key, not otherKey"))
+	require.NoError(t, err)
+
+	_, err = otherKey.open(sealed)
+	require.Error(t, err)
+}
+
+func TestHashAndDataKeyDoNotShareKeyMaterial(t *testing.T) {
+	var key EncryptionKey
+	_, err := rand.Read(key[:])
+	require.NoError(t, err)
+
+	hash := key.Hash()
+	dataKey := key.dataKey()
+
+	require.NotEqual(t, hash[:], dataKey[:])
+
+	// Both are deterministic functions of the EncryptionKey.
+	require.Equal(t, hash, key.Hash())
+	require.Equal(t, dataKey, key.dataKey())
+}
+
+// TestGetRejectsTamperedRecord exercises Database.Get's tamper handling
+// directly against a hand-sealed Record, without going through Put, since
+// Put requires a real *uplink.Access (satellite address, API key, and
+// encryption access) that a unit test can't construct without talking to a
+// satellite. Get must fail closed the moment either ciphertext doesn't
+// authenticate, before ever reaching uplink.ParseAccess.
+func TestGetRejectsTamperedRecord(t *testing.T) {
+	ctx := context.Background()
+
+	var key EncryptionKey
+	_, err := rand.Read(key[:])
+	require.NoError(t, err)
+
+	sealedSecret, err := key.seal([]byte("a secret key"))
+	require.NoError(t, err)
+	sealedGrant, err := key.seal([]byte("a serialized access grant"))
+	require.NoError(t, err)
+
+	kv := memauth.New()
+	db := NewDatabase(kv)
+
+	t.Run("tampered secret key", func(t *testing.T) {
+		tamperedSecret := append([]byte(nil), sealedSecret...)
+		tamperedSecret[0] ^= 0xff
+
+		require.NoError(t, kv.Put(ctx, key.Hash(), &Record{
+			SatelliteAddress:     "satellite.test:7777",
+			MacaroonHead:         []byte("head"),
+			EncryptedSecretKey:   tamperedSecret,
+			EncryptedAccessGrant: sealedGrant,
+		}))
+		defer func() { require.NoError(t, kv.Delete(ctx, key.Hash())) }()
+
+		_, _, err := db.Get(ctx, key)
+		require.Error(t, err)
+		require.True(t, Invalid.Has(err))
+	})
+
+	t.Run("tampered access grant", func(t *testing.T) {
+		tamperedGrant := append([]byte(nil), sealedGrant...)
+		tamperedGrant[0] ^= 0xff
+
+		require.NoError(t, kv.Put(ctx, key.Hash(), &Record{
+			SatelliteAddress:     "satellite.test:7777",
+			MacaroonHead:         []byte("head"),
+			EncryptedSecretKey:   sealedSecret,
+			EncryptedAccessGrant: tamperedGrant,
+		}))
+		defer func() { require.NoError(t, kv.Delete(ctx, key.Hash())) }()
+
+		_, _, err := db.Get(ctx, key)
+		require.Error(t, err)
+		require.True(t, Invalid.Has(err))
+	})
+}
+
+func TestGetNotFound(t *testing.T) {
+	ctx := context.Background()
+
+	var key EncryptionKey
+	_, err := rand.Read(key[:])
+	require.NoError(t, err)
+
+	db := NewDatabase(memauth.New())
+
+	_, _, err = db.Get(ctx, key)
+	require.Error(t, err)
+	require.True(t, NotFound.Has(err))
+}
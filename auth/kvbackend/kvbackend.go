@@ -0,0 +1,50 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package kvbackend selects and constructs an auth.KV implementation from
+// configuration, backing the gateway's --kv-backend flag.
+package kvbackend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"storj.io/gateway-mt/auth"
+	"storj.io/gateway-mt/auth/boltauth"
+	"storj.io/gateway-mt/auth/memauth"
+	"storj.io/gateway-mt/auth/pgauth"
+	"storj.io/gateway-mt/auth/redisauth"
+)
+
+// Config selects and configures an auth.KV backend.
+type Config struct {
+	Backend string `help:"key/value backend to use: memory, postgres, redis, or bolt" default:"memory"`
+	DSN     string `help:"data source name/connection string for the selected backend, ignored for memory"`
+}
+
+// Open constructs the auth.KV backend selected by config.
+func Open(ctx context.Context, config Config) (auth.KV, error) {
+	switch config.Backend {
+	case "", "memory":
+		return memauth.New(), nil
+
+	case "postgres":
+		return pgauth.Open(ctx, config.DSN)
+
+	case "redis":
+		opts, err := redis.ParseURL(config.DSN)
+		if err != nil {
+			return nil, err
+		}
+		return redisauth.Open(opts, 0*time.Second), nil
+
+	case "bolt":
+		return boltauth.Open(config.DSN)
+
+	default:
+		return nil, fmt.Errorf("unknown kv-backend %q", config.Backend)
+	}
+}
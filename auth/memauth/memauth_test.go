@@ -0,0 +1,18 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package memauth_test
+
+import (
+	"testing"
+
+	"storj.io/gateway-mt/auth"
+	"storj.io/gateway-mt/auth/authtest"
+	"storj.io/gateway-mt/auth/memauth"
+)
+
+func TestKV(t *testing.T) {
+	authtest.RunKVConformance(t, func(t *testing.T) auth.KV {
+		return memauth.New()
+	})
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package pgauth implements auth.KV backed by PostgreSQL.
+package pgauth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+
+	"storj.io/gateway-mt/auth"
+)
+
+var mon = monkit.Package()
+
+// Error is the class of errors returned by this package.
+var Error = errs.Class("pgauth")
+
+// Schema is the SQL needed to create the records table used by KV.
+const Schema = `
+CREATE TABLE IF NOT EXISTS records (
+	key_hash               BYTEA PRIMARY KEY,
+	satellite_address      TEXT NOT NULL,
+	macaroon_head          BYTEA NOT NULL,
+	encrypted_secret_key   BYTEA NOT NULL,
+	encrypted_access_grant BYTEA NOT NULL,
+	invalid_reason         TEXT,
+	created_at             TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`
+
+// KV is a key/value store backed by PostgreSQL.
+type KV struct {
+	pool *pgxpool.Pool
+}
+
+// Open connects to the PostgreSQL database at dsn and returns a KV backed by
+// it. Callers are responsible for applying Schema beforehand.
+func Open(ctx context.Context, dsn string) (*KV, error) {
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return &KV{pool: pool}, nil
+}
+
+// Close releases the underlying connection pool.
+func (kv *KV) Close() error {
+	kv.pool.Close()
+	return nil
+}
+
+// Put stores the record in the key/value store.
+// It is an error if the key already exists.
+func (kv *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	tag, err := kv.pool.Exec(ctx, `
+		INSERT INTO records (key_hash, satellite_address, macaroon_head, encrypted_secret_key, encrypted_access_grant)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (key_hash) DO NOTHING
+	`, keyHash[:], record.SatelliteAddress, record.MacaroonHead, record.EncryptedSecretKey, record.EncryptedAccessGrant)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errs.New("record already exists")
+	}
+	return nil
+}
+
+// Get retrieves the record from the key/value store.
+// It returns nil if the key does not exist.
+func (kv *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var r auth.Record
+	var invalidReason *string
+
+	row := kv.pool.QueryRow(ctx, `
+		SELECT satellite_address, macaroon_head, encrypted_secret_key, encrypted_access_grant, invalid_reason
+		FROM records WHERE key_hash = $1
+	`, keyHash[:])
+	err = row.Scan(&r.SatelliteAddress, &r.MacaroonHead, &r.EncryptedSecretKey, &r.EncryptedAccessGrant, &invalidReason)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	if invalidReason != nil {
+		return nil, auth.Invalid.New("%s", *invalidReason)
+	}
+
+	return &r, nil
+}
+
+// Delete removes the record from the key/value store.
+// It is not an error if the key does not exist.
+func (kv *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = kv.pool.Exec(ctx, `DELETE FROM records WHERE key_hash = $1`, keyHash[:])
+	return Error.Wrap(err)
+}
+
+// Invalidate causes the record to become invalid.
+// It is not an error if the key does not exist.
+// It does not update the invalid reason if the record is already invalid.
+func (kv *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = kv.pool.Exec(ctx, `
+		UPDATE records SET invalid_reason = $2 WHERE key_hash = $1 AND invalid_reason IS NULL
+	`, keyHash[:], reason)
+	return Error.Wrap(err)
+}
+
+// Ping attempts to do a database roundtrip and returns an error if it can't.
+func (kv *KV) Ping(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return Error.Wrap(kv.pool.Ping(ctx))
+}
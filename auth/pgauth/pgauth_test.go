@@ -0,0 +1,49 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package pgauth_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/gateway-mt/auth"
+	"storj.io/gateway-mt/auth/authtest"
+	"storj.io/gateway-mt/auth/pgauth"
+)
+
+// dsnEnv names the environment variable that points at a scratch PostgreSQL
+// database to test against. The suite is skipped when it isn't set, since
+// there's no in-process substitute for a real PostgreSQL server.
+const dsnEnv = "STORJ_TEST_POSTGRES"
+
+func TestKV(t *testing.T) {
+	dsn := os.Getenv(dsnEnv)
+	if dsn == "" {
+		t.Skipf("%s not set, skipping pgauth conformance tests", dsnEnv)
+	}
+
+	ctx := context.Background()
+
+	pool, err := pgxpool.Connect(ctx, dsn)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	authtest.RunKVConformance(t, func(t *testing.T) auth.KV {
+		_, err := pool.Exec(ctx, pgauth.Schema)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_, err := pool.Exec(ctx, `TRUNCATE records`)
+			require.NoError(t, err)
+		})
+
+		kv, err := pgauth.Open(ctx, dsn)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = kv.Close() })
+		return kv
+	})
+}
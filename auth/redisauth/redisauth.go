@@ -0,0 +1,124 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package redisauth implements auth.KV backed by Redis.
+package redisauth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/zeebo/errs"
+
+	"storj.io/gateway-mt/auth"
+)
+
+var mon = monkit.Package()
+
+// Error is the class of errors returned by this package.
+var Error = errs.Class("redisauth")
+
+// recordKeyPrefix and invalidKeyPrefix separate records from their
+// invalidation markers so a record can be deleted without disturbing (or
+// accidentally resurrecting) an invalidation, and vice versa.
+const (
+	recordKeyPrefix  = "record:"
+	invalidKeyPrefix = "invalid:"
+)
+
+// KV is a key/value store backed by Redis. Records are stored as MessagePack
+// blobs, optionally with a TTL.
+type KV struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// Open constructs a KV backed by the Redis server described by opts. ttl, if
+// non-zero, is applied to both records and invalidation markers.
+func Open(opts *redis.Options, ttl time.Duration) *KV {
+	return &KV{client: redis.NewClient(opts), ttl: ttl}
+}
+
+// Close releases the underlying client.
+func (kv *KV) Close() error {
+	return Error.Wrap(kv.client.Close())
+}
+
+func recordKey(keyHash auth.KeyHash) string  { return recordKeyPrefix + string(keyHash[:]) }
+func invalidKey(keyHash auth.KeyHash) string { return invalidKeyPrefix + string(keyHash[:]) }
+
+// Put stores the record in the key/value store.
+// It is an error if the key already exists.
+func (kv *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	data, err := msgpack.Marshal(record)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	ok, err := kv.client.SetNX(ctx, recordKey(keyHash), data, kv.ttl).Result()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	if !ok {
+		return errs.New("record already exists")
+	}
+	return nil
+}
+
+// Get retrieves the record from the key/value store.
+// It returns nil if the key does not exist.
+func (kv *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	reason, err := kv.client.Get(ctx, invalidKey(keyHash)).Result()
+	if err == nil {
+		return nil, auth.Invalid.New("%s", reason)
+	} else if !errors.Is(err, redis.Nil) {
+		return nil, Error.Wrap(err)
+	}
+
+	data, err := kv.client.Get(ctx, recordKey(keyHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	record = &auth.Record{}
+	if err := msgpack.Unmarshal(data, record); err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return record, nil
+}
+
+// Delete removes the record from the key/value store.
+// It is not an error if the key does not exist.
+func (kv *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = kv.client.Del(ctx, recordKey(keyHash), invalidKey(keyHash)).Result()
+	return Error.Wrap(err)
+}
+
+// Invalidate causes the record to become invalid.
+// It is not an error if the key does not exist.
+// It does not update the invalid reason if the record is already invalid.
+func (kv *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = kv.client.SetNX(ctx, invalidKey(keyHash), reason, kv.ttl).Result()
+	return Error.Wrap(err)
+}
+
+// Ping attempts to do a database roundtrip and returns an error if it can't.
+func (kv *KV) Ping(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return Error.Wrap(kv.client.Ping(ctx).Err())
+}
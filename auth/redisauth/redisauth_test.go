@@ -0,0 +1,41 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package redisauth_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/gateway-mt/auth"
+	"storj.io/gateway-mt/auth/authtest"
+	"storj.io/gateway-mt/auth/redisauth"
+)
+
+// addrEnv names the environment variable that points at a scratch Redis
+// server to test against. The suite is skipped when it isn't set, since
+// there's no in-process substitute for a real Redis server.
+const addrEnv = "STORJ_TEST_REDIS_ADDR"
+
+func TestKV(t *testing.T) {
+	addr := os.Getenv(addrEnv)
+	if addr == "" {
+		t.Skipf("%s not set, skipping redisauth conformance tests", addrEnv)
+	}
+
+	authtest.RunKVConformance(t, func(t *testing.T) auth.KV {
+		kv := redisauth.Open(&redis.Options{Addr: addr}, 0*time.Second)
+		t.Cleanup(func() {
+			client := redis.NewClient(&redis.Options{Addr: addr})
+			defer func() { _ = client.Close() }()
+			_ = client.FlushDB(context.Background()).Err()
+			_ = kv.Close()
+		})
+		return kv
+	})
+}
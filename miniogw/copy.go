@@ -0,0 +1,132 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+	"time"
+
+	minio "github.com/storj/minio/cmd"
+	"github.com/storj/minio/pkg/hash"
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+	"storj.io/uplink/private/multipart"
+)
+
+// CopyObjectPart copies a range of an existing Storj object into a part of
+// an in-progress multipart upload, so clients can assemble a large object
+// out of ranges of existing objects without round-tripping the bytes
+// through the client, matching the S3 x-amz-copy-source semantics.
+func (layer *gatewayLayer) CopyObjectPart(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, uploadID string, partID int, startOffset, length int64, srcInfo minio.ObjectInfo, srcOpts, dstOpts minio.ObjectOptions) (info minio.PartInfo, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := checkCopyObjectPartPreconditions(srcInfo, srcOpts); err != nil {
+		return minio.PartInfo{}, err
+	}
+
+	project, err := layer.openProjectMultipart(ctx, getAccessGrant(ctx))
+	if err != nil {
+		return minio.PartInfo{}, err
+	}
+	defer func() {
+		err = errs.Combine(err, project.Close())
+	}()
+
+	download, err := project.DownloadObject(ctx, srcBucket, srcObject, &uplink.DownloadOptions{
+		Offset: startOffset,
+		Length: length,
+	})
+	if err != nil {
+		return minio.PartInfo{}, convertError(err, srcBucket, srcObject)
+	}
+	defer func() {
+		err = errs.Combine(err, download.Close())
+	}()
+
+	store := newObjectPartStore(project)
+
+	// The destination upload may have been created with SSE-C/SSE-S3: if
+	// so, the copied bytes must be encrypted under its key the same way
+	// PutObjectPart encrypts directly-uploaded bytes, or this part would be
+	// the only one in the object that isn't.
+	meta, err := store.readMeta(ctx, destBucket, uploadID)
+	if err != nil {
+		return minio.PartInfo{}, err
+	}
+
+	var src io.Reader = download
+	var sseNonce []byte
+	if meta.SSE != nil {
+		key, err := resolvePartKey(*meta.SSE, dstOpts.UserDefined)
+		if err != nil {
+			return minio.PartInfo{}, err
+		}
+
+		sseNonce, err = newPartNonce()
+		if err != nil {
+			return minio.PartInfo{}, errs.Wrap(err)
+		}
+
+		src, err = newSSEReader(key, meta.SSE.IV, sseNonce, partID, download)
+		if err != nil {
+			return minio.PartInfo{}, errs.Wrap(err)
+		}
+	}
+
+	reader, err := hash.NewReader(src, length, "", "", length)
+	if err != nil {
+		return minio.PartInfo{}, errs.Wrap(err)
+	}
+
+	partInfo, err := multipart.PutObjectPart(ctx, project, destBucket, destObject, uploadID, partID-1, minio.NewPutObjReader(reader, nil, nil))
+	if err != nil {
+		return minio.PartInfo{}, convertMultipartError(err, destBucket, destObject, uploadID)
+	}
+
+	// Propagate the source's ETag rather than recomputing one: the copied
+	// bytes are identical to the source range they came from.
+	etag := canonicalEtag(srcInfo.ETag)
+	lastModified := time.Now()
+
+	err = store.PutPart(ctx, destBucket, destObject, uploadID, PartRecord{
+		PartNumber:   partID,
+		Size:         partInfo.Size,
+		ETag:         etag,
+		LastModified: lastModified,
+		SSENonce:     sseNonce,
+	})
+	if err != nil {
+		return minio.PartInfo{}, err
+	}
+
+	return minio.PartInfo{
+		PartNumber:   partID,
+		Size:         partInfo.Size,
+		ETag:         etag,
+		LastModified: lastModified,
+	}, nil
+}
+
+// checkCopyObjectPartPreconditions validates the x-amz-copy-source-if-*
+// preconditions (surfaced to us via srcOpts.UserDefined, the same way other
+// per-request header values already flow through ObjectOptions in this
+// package) against the source object's current ETag and modification time.
+func checkCopyObjectPartPreconditions(srcInfo minio.ObjectInfo, srcOpts minio.ObjectOptions) error {
+	if ifMatch := srcOpts.UserDefined["x-amz-copy-source-if-match"]; ifMatch != "" {
+		if canonicalEtag(ifMatch) != canonicalEtag(srcInfo.ETag) {
+			return minio.PreConditionFailed{}
+		}
+	}
+
+	if ifModifiedSince := srcOpts.UserDefined["x-amz-copy-source-if-modified-since"]; ifModifiedSince != "" {
+		t, err := time.Parse(time.RFC1123, ifModifiedSince)
+		if err == nil && !srcInfo.ModTime.After(t) {
+			return minio.PreConditionFailed{}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"testing"
+	"time"
+
+	minio "github.com/storj/minio/cmd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCopyObjectPartPreconditionsAcceptsNoPreconditions(t *testing.T) {
+	srcInfo := minio.ObjectInfo{ETag: "abc123", ModTime: time.Now()}
+
+	require.NoError(t, checkCopyObjectPartPreconditions(srcInfo, minio.ObjectOptions{}))
+}
+
+func TestCheckCopyObjectPartPreconditionsIfMatch(t *testing.T) {
+	srcInfo := minio.ObjectInfo{ETag: "abc123"}
+
+	t.Run("matching ETag is accepted", func(t *testing.T) {
+		opts := minio.ObjectOptions{UserDefined: map[string]string{
+			"x-amz-copy-source-if-match": `"abc123"`,
+		}}
+		require.NoError(t, checkCopyObjectPartPreconditions(srcInfo, opts))
+	})
+
+	t.Run("mismatched ETag is rejected", func(t *testing.T) {
+		opts := minio.ObjectOptions{UserDefined: map[string]string{
+			"x-amz-copy-source-if-match": `"not-the-etag"`,
+		}}
+		err := checkCopyObjectPartPreconditions(srcInfo, opts)
+		require.Error(t, err)
+		require.IsType(t, minio.PreConditionFailed{}, err)
+	})
+}
+
+func TestCheckCopyObjectPartPreconditionsIfModifiedSince(t *testing.T) {
+	modTime := time.Date(2020, time.June, 15, 12, 0, 0, 0, time.UTC)
+	srcInfo := minio.ObjectInfo{ETag: "abc123", ModTime: modTime}
+
+	t.Run("source modified after the given time is accepted", func(t *testing.T) {
+		opts := minio.ObjectOptions{UserDefined: map[string]string{
+			"x-amz-copy-source-if-modified-since": modTime.Add(-time.Hour).Format(time.RFC1123),
+		}}
+		require.NoError(t, checkCopyObjectPartPreconditions(srcInfo, opts))
+	})
+
+	t.Run("source not modified since the given time is rejected", func(t *testing.T) {
+		opts := minio.ObjectOptions{UserDefined: map[string]string{
+			"x-amz-copy-source-if-modified-since": modTime.Add(time.Hour).Format(time.RFC1123),
+		}}
+		err := checkCopyObjectPartPreconditions(srcInfo, opts)
+		require.Error(t, err)
+		require.IsType(t, minio.PreConditionFailed{}, err)
+	})
+
+	t.Run("unparseable header is ignored rather than rejected", func(t *testing.T) {
+		opts := minio.ObjectOptions{UserDefined: map[string]string{
+			"x-amz-copy-source-if-modified-since": "not-a-valid-time",
+		}}
+		require.NoError(t, checkCopyObjectPartPreconditions(srcInfo, opts))
+	})
+}
@@ -0,0 +1,244 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"storj.io/uplink"
+	"storj.io/uplink/private/multipart"
+)
+
+// JanitorConfig configures the background janitor that aborts abandoned
+// multipart uploads, following the pattern MinIO's FS backend uses
+// (fsMultipartExpiry plus a periodic cleanup goroutine).
+type JanitorConfig struct {
+	Expiry          time.Duration `help:"time after creation an incomplete multipart upload is considered abandoned" default:"336h"`
+	CleanupInterval time.Duration `help:"how often the janitor looks for abandoned multipart uploads" default:"1h"`
+	CleanupDisabled bool          `help:"disable the background multipart upload janitor" default:"false"`
+}
+
+// Janitor periodically walks multipart uploads across the buckets visible
+// to its project and aborts any upload whose System.Created timestamp is
+// older than config.Expiry.
+//
+// It is safe to run a Janitor in multiple gateway replicas at once: each
+// run's first tick is jittered so replicas don't sweep in lockstep, and
+// aborting an upload another replica already cleaned up is treated as a
+// skip rather than an error.
+type Janitor struct {
+	log     *zap.Logger
+	config  JanitorConfig
+	project func(ctx context.Context) (*uplink.Project, error)
+}
+
+// NewJanitor constructs a Janitor. openProject is called once per cleanup
+// pass to obtain an *uplink.Project with visibility into every bucket the
+// janitor should clean up.
+func NewJanitor(log *zap.Logger, config JanitorConfig, openProject func(ctx context.Context) (*uplink.Project, error)) *Janitor {
+	return &Janitor{
+		log:     log,
+		config:  config,
+		project: openProject,
+	}
+}
+
+// Run blocks, periodically cleaning up abandoned multipart uploads until
+// ctx is canceled.
+func (janitor *Janitor) Run(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if janitor.config.CleanupDisabled {
+		return nil
+	}
+
+	// Jitter the first run so that replicas starting at the same instant
+	// don't all sweep in lockstep.
+	jitter := time.Duration(rand.Int63n(int64(janitor.config.CleanupInterval) + 1))
+	timer := time.NewTimer(jitter)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			janitor.cleanupOnce(ctx)
+			timer.Reset(janitor.config.CleanupInterval)
+		}
+	}
+}
+
+func (janitor *Janitor) cleanupOnce(ctx context.Context) {
+	project, err := janitor.project(ctx)
+	if err != nil {
+		janitor.log.Error("janitor: failed to open project", zap.Error(err))
+		mon.Counter("multipart_janitor_errors").Inc(1)
+		return
+	}
+	defer func() { _ = project.Close() }()
+
+	buckets := project.ListBuckets(ctx, nil)
+	for buckets.Next() {
+		bucket := buckets.Item().Name
+		if bucket == multipartMetaBucket {
+			// Our own reserved bucket never holds a multipart upload itself,
+			// only the metadata objects describing uploads to tenant
+			// buckets; nothing there for the sweep to find.
+			continue
+		}
+		janitor.cleanupBucket(ctx, project, bucket)
+	}
+	if err := buckets.Err(); err != nil {
+		janitor.log.Error("janitor: failed to list buckets", zap.Error(err))
+		mon.Counter("multipart_janitor_errors").Inc(1)
+	}
+}
+
+func (janitor *Janitor) cleanupBucket(ctx context.Context, project *uplink.Project, bucket string) {
+	janitor.sweep(ctx, projectJanitorTarget{project}, bucket)
+}
+
+// abandonedUpload is the subset of a multipart.Object the sweep needs to
+// decide whether to abort it.
+type abandonedUpload struct {
+	Object   string
+	UploadID string
+	Created  time.Time
+}
+
+// janitorTarget is the multipart-upload sweep and abort operations cleanup
+// needs, abstracted out of cleanupBucket so the sweep logic can be
+// unit-tested against a fake instead of a live uplink project.
+type janitorTarget interface {
+	// listAbandoned returns every incomplete multipart upload in bucket.
+	listAbandoned(ctx context.Context, bucket string) ([]abandonedUpload, error)
+	// abort aborts the upload and removes its recorded part metadata. It
+	// is the janitor's equivalent of gatewayLayer.AbortMultipartUpload,
+	// which also deletes part metadata that nothing else would otherwise
+	// ever clean up.
+	abort(ctx context.Context, bucket, object, uploadID string) error
+	// reapOrphanedMetadata deletes any recorded part metadata for bucket
+	// whose upload ID isn't in liveUploadIDs. It is the backstop for the
+	// rare case where deleteUploadPartsBestEffort's delete failed right
+	// after an abort or complete already succeeded: without it, that
+	// metadata object would never be reclaimed, since the upload it
+	// describes is gone and listAbandoned can no longer find it.
+	reapOrphanedMetadata(ctx context.Context, bucket string, liveUploadIDs map[string]bool) error
+}
+
+// sweep aborts every upload target reports as abandoned and older than
+// config.Expiry.
+func (janitor *Janitor) sweep(ctx context.Context, target janitorTarget, bucket string) {
+	cutoff := time.Now().Add(-janitor.config.Expiry)
+
+	uploads, err := target.listAbandoned(ctx, bucket)
+	if err != nil {
+		janitor.log.Error("janitor: failed to list multipart uploads",
+			zap.String("bucket", bucket), zap.Error(err))
+		mon.Counter("multipart_janitor_errors").Inc(1)
+		return
+	}
+
+	for _, upload := range uploads {
+		if upload.Created.After(cutoff) {
+			mon.Counter("multipart_janitor_skipped").Inc(1)
+			continue
+		}
+
+		if err := target.abort(ctx, bucket, upload.Object, upload.UploadID); err != nil {
+			if errors.Is(err, multipart.ErrStreamIDInvalid) {
+				// Another replica's sweep (or the client itself) already
+				// aborted or completed this upload between our listAbandoned
+				// call and this abort call: exactly the race this janitor
+				// is designed to run safely into, not a failure.
+				mon.Counter("multipart_janitor_skipped").Inc(1)
+				continue
+			}
+
+			janitor.log.Warn("janitor: failed to abort abandoned multipart upload",
+				zap.String("bucket", bucket), zap.String("object", upload.Object), zap.Error(err))
+			mon.Counter("multipart_janitor_errors").Inc(1)
+			continue
+		}
+
+		janitor.log.Info("janitor: aborted abandoned multipart upload",
+			zap.String("bucket", bucket), zap.String("object", upload.Object),
+			zap.Time("created", upload.Created))
+		mon.Counter("multipart_janitor_aborted").Inc(1)
+	}
+
+	live := make(map[string]bool, len(uploads))
+	for _, upload := range uploads {
+		live[upload.UploadID] = true
+	}
+	if err := target.reapOrphanedMetadata(ctx, bucket, live); err != nil {
+		janitor.log.Error("janitor: failed to reap orphaned multipart metadata",
+			zap.String("bucket", bucket), zap.Error(err))
+		mon.Counter("multipart_janitor_errors").Inc(1)
+	}
+}
+
+// projectJanitorTarget is the janitorTarget backed by a live uplink
+// project, routing abort through PartStore.DeleteParts the same way
+// gatewayLayer.AbortMultipartUpload does so cleanup doesn't leave the
+// aborted upload's metadata object orphaned in the bucket.
+type projectJanitorTarget struct {
+	project *uplink.Project
+}
+
+func (t projectJanitorTarget) listAbandoned(ctx context.Context, bucket string) ([]abandonedUpload, error) {
+	var uploads []abandonedUpload
+
+	list := multipart.ListMultipartUploads(ctx, t.project, bucket, &multipart.ListMultipartUploadsOptions{
+		Recursive: true,
+		System:    true,
+	})
+	for list.Next() {
+		upload := list.Item()
+		if upload.IsPrefix {
+			continue
+		}
+		uploads = append(uploads, abandonedUpload{
+			Object:   upload.Key,
+			UploadID: upload.StreamID,
+			Created:  upload.System.Created,
+		})
+	}
+	return uploads, list.Err()
+}
+
+func (t projectJanitorTarget) abort(ctx context.Context, bucket, object, uploadID string) error {
+	if err := multipart.AbortMultipartUpload(ctx, t.project, bucket, object, uploadID); err != nil {
+		return err
+	}
+	deleteUploadPartsBestEffort(ctx, newObjectPartStore(t.project), bucket, object, uploadID)
+	return nil
+}
+
+func (t projectJanitorTarget) reapOrphanedMetadata(ctx context.Context, bucket string, liveUploadIDs map[string]bool) error {
+	prefix := multipartMetaPrefix + bucket + "/"
+
+	objects := t.project.ListObjects(ctx, multipartMetaBucket, &uplink.ListObjectsOptions{Prefix: prefix})
+	for objects.Next() {
+		key := objects.Item().Key
+		if liveUploadIDs[strings.TrimPrefix(key, prefix)] {
+			continue
+		}
+		if err := t.project.DeleteObject(ctx, multipartMetaBucket, key); err != nil && !errors.Is(err, uplink.ErrObjectNotFound) {
+			return err
+		}
+	}
+
+	if err := objects.Err(); err != nil && !errors.Is(err, uplink.ErrBucketNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+
+	"storj.io/uplink/private/multipart"
+)
+
+// fakeJanitorTarget is a janitorTarget backed by an in-memory list of
+// uploads, standing in for a live uplink project in tests.
+type fakeJanitorTarget struct {
+	uploads []abandonedUpload
+	aborted []string
+	reaped  map[string]bool
+}
+
+func (f *fakeJanitorTarget) listAbandoned(ctx context.Context, bucket string) ([]abandonedUpload, error) {
+	return f.uploads, nil
+}
+
+func (f *fakeJanitorTarget) abort(ctx context.Context, bucket, object, uploadID string) error {
+	f.aborted = append(f.aborted, uploadID)
+	return nil
+}
+
+func (f *fakeJanitorTarget) reapOrphanedMetadata(ctx context.Context, bucket string, liveUploadIDs map[string]bool) error {
+	f.reaped = liveUploadIDs
+	return nil
+}
+
+func TestJanitorSweepAbortsOnlyExpiredUploads(t *testing.T) {
+	janitor := NewJanitor(zaptest.NewLogger(t), JanitorConfig{Expiry: time.Hour}, nil)
+
+	target := &fakeJanitorTarget{
+		uploads: []abandonedUpload{
+			{Object: "old", UploadID: "old-id", Created: time.Now().Add(-2 * time.Hour)},
+			{Object: "new", UploadID: "new-id", Created: time.Now()},
+		},
+	}
+
+	janitor.sweep(context.Background(), target, "bucket")
+
+	require.Equal(t, []string{"old-id"}, target.aborted)
+	require.Equal(t, map[string]bool{"old-id": true, "new-id": true}, target.reaped,
+		"both uploads are still live going into the reap pass regardless of which one just got aborted")
+}
+
+type erroringJanitorTarget struct {
+	abandonedUpload []abandonedUpload
+	attempted       []string
+}
+
+func (f *erroringJanitorTarget) listAbandoned(ctx context.Context, bucket string) ([]abandonedUpload, error) {
+	return f.abandonedUpload, nil
+}
+
+func (f *erroringJanitorTarget) abort(ctx context.Context, bucket, object, uploadID string) error {
+	f.attempted = append(f.attempted, uploadID)
+	return require.AnError
+}
+
+func (f *erroringJanitorTarget) reapOrphanedMetadata(ctx context.Context, bucket string, liveUploadIDs map[string]bool) error {
+	return nil
+}
+
+func TestJanitorSweepContinuesAfterAbortError(t *testing.T) {
+	janitor := NewJanitor(zaptest.NewLogger(t), JanitorConfig{Expiry: time.Hour}, nil)
+
+	target := &erroringJanitorTarget{
+		abandonedUpload: []abandonedUpload{
+			{Object: "a", UploadID: "a-id", Created: time.Now().Add(-2 * time.Hour)},
+			{Object: "b", UploadID: "b-id", Created: time.Now().Add(-2 * time.Hour)},
+		},
+	}
+
+	// Must not panic or stop early; both uploads are attempted even though
+	// abort fails for each.
+	janitor.sweep(context.Background(), target, "bucket")
+
+	require.Equal(t, []string{"a-id", "b-id"}, target.attempted)
+}
+
+// alreadyAbortedJanitorTarget is a janitorTarget whose abort reports the
+// upload as already gone, the way a live uplink project does (via
+// multipart.ErrStreamIDInvalid) when another replica's sweep - or the
+// client itself - already aborted or completed it first.
+type alreadyAbortedJanitorTarget struct {
+	abandonedUpload []abandonedUpload
+}
+
+func (f *alreadyAbortedJanitorTarget) listAbandoned(ctx context.Context, bucket string) ([]abandonedUpload, error) {
+	return f.abandonedUpload, nil
+}
+
+func (f *alreadyAbortedJanitorTarget) abort(ctx context.Context, bucket, object, uploadID string) error {
+	return multipart.ErrStreamIDInvalid
+}
+
+func (f *alreadyAbortedJanitorTarget) reapOrphanedMetadata(ctx context.Context, bucket string, liveUploadIDs map[string]bool) error {
+	return nil
+}
+
+// TestJanitorSweepTreatsAlreadyAbortedAsSkipNotError confirms the race this
+// janitor is explicitly designed to run safely into - another replica
+// aborting the same upload first - is counted as a skip, not logged as the
+// warning a genuine abort failure gets.
+func TestJanitorSweepTreatsAlreadyAbortedAsSkipNotError(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	log := zap.New(core)
+
+	janitor := NewJanitor(log, JanitorConfig{Expiry: time.Hour}, nil)
+
+	target := &alreadyAbortedJanitorTarget{
+		abandonedUpload: []abandonedUpload{
+			{Object: "a", UploadID: "a-id", Created: time.Now().Add(-2 * time.Hour)},
+		},
+	}
+
+	janitor.sweep(context.Background(), target, "bucket")
+
+	require.Equal(t, 0, logs.Len(), "an already-aborted upload must not be logged as a warning")
+}
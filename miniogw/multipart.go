@@ -11,8 +11,11 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	minio "github.com/storj/minio/cmd"
+	"github.com/storj/minio/cmd/crypto"
+	"github.com/storj/minio/pkg/hash"
 	"github.com/zeebo/errs"
 
 	"storj.io/uplink"
@@ -46,13 +49,77 @@ func (layer *gatewayLayer) NewMultipartUpload(ctx context.Context, bucket, objec
 		err = errs.Combine(err, project.Close())
 	}()
 
+	sse, err := newUploadSSEState(opts.UserDefined)
+	if err != nil {
+		return "", err
+	}
+
 	info, err := multipart.NewMultipartUpload(ctx, project, bucket, object, nil)
 	if err != nil {
 		return "", convertMultipartError(err, bucket, object, "")
 	}
+
+	if sse != nil {
+		if err := newObjectPartStore(project).PutUploadSSE(ctx, bucket, object, info.StreamID, *sse); err != nil {
+			return "", err
+		}
+	}
+
 	return info.StreamID, nil
 }
 
+// newUploadSSEState builds the SSE state for a new multipart upload from
+// the SSE-C/SSE-S3 headers on the request, or returns nil if neither was
+// requested.
+func newUploadSSEState(userDefined map[string]string) (*sseState, error) {
+	customerKey, err := parseCustomerKey(userDefined)
+	if err != nil {
+		return nil, err
+	}
+
+	sses3, err := isSSES3Requested(userDefined)
+	if err != nil {
+		return nil, err
+	}
+
+	if (customerKey != nil || sses3) && !sseConfig.Enabled {
+		// GetObject/HeadObject don't decrypt yet, so honoring these headers
+		// would seal the object's bytes away forever.
+		return nil, minio.NotImplemented{}
+	}
+
+	switch {
+	case customerKey != nil:
+		iv, sealedKey, err := sealCheckValue(customerKey.Key)
+		if err != nil {
+			return nil, errs.Wrap(err)
+		}
+		return &sseState{Algorithm: sseAlgorithmAES256, IV: iv, SealedKey: sealedKey, KeyMD5: customerKey.KeyMD5}, nil
+
+	case sses3:
+		masterKey, ok, err := sseConfig.masterKey()
+		if err != nil {
+			return nil, errs.Wrap(err)
+		}
+		if !ok {
+			return nil, crypto.ErrInvalidSSEParameters
+		}
+
+		salt, objectKey, err := deriveSSES3Key(masterKey)
+		if err != nil {
+			return nil, errs.Wrap(err)
+		}
+		iv, sealedKey, err := sealCheckValue(objectKey)
+		if err != nil {
+			return nil, errs.Wrap(err)
+		}
+		return &sseState{Algorithm: sseAlgorithmAES256, Salt: salt, IV: iv, SealedKey: sealedKey}, nil
+
+	default:
+		return nil, nil
+	}
+}
+
 func (layer *gatewayLayer) GetMultipartInfo(ctx context.Context, bucket string, object string, uploadID string, opts minio.ObjectOptions) (info minio.MultipartInfo, err error) {
 	info.Bucket = bucket
 	info.Object = object
@@ -72,17 +139,74 @@ func (layer *gatewayLayer) PutObjectPart(ctx context.Context, bucket, object, up
 		err = errs.Combine(err, project.Close())
 	}()
 
-	partInfo, err := multipart.PutObjectPart(ctx, project, bucket, object, uploadID, partID-1, data)
+	store := newObjectPartStore(project)
+
+	// Hold the upload's lock across the whole read-modify-write: its SSE
+	// state is read before the part is uploaded, and its part list is
+	// written back afterwards, and a concurrent part upload must not read
+	// the same metadata in between and clobber this write.
+	defer store.lock(bucket, uploadID)()
+
+	// Load the upload's metadata once: its SSE state is needed before the
+	// part is uploaded, and its part list needs updating afterwards, so one
+	// read-modify-write round trip covers both instead of two.
+	meta, err := store.readMeta(ctx, bucket, uploadID)
+	if err != nil {
+		return minio.PartInfo{}, err
+	}
+
+	putData := data
+	etagSource := data.MD5CurrentHexString
+	var sseNonce []byte
+
+	if meta.SSE != nil {
+		key, err := resolvePartKey(*meta.SSE, opts.UserDefined)
+		if err != nil {
+			return minio.PartInfo{}, err
+		}
+
+		sseNonce, err = newPartNonce()
+		if err != nil {
+			return minio.PartInfo{}, errs.Wrap(err)
+		}
+
+		encrypted, err := newSSEReader(key, meta.SSE.IV, sseNonce, partID, data)
+		if err != nil {
+			return minio.PartInfo{}, errs.Wrap(err)
+		}
+
+		reader, err := hash.NewReader(encrypted, data.Size(), "", "", data.Size())
+		if err != nil {
+			return minio.PartInfo{}, errs.Wrap(err)
+		}
+		putData = minio.NewPutObjReader(reader, nil, nil)
+		etagSource = putData.MD5CurrentHexString
+	}
+
+	partInfo, err := multipart.PutObjectPart(ctx, project, bucket, object, uploadID, partID-1, putData)
 	if err != nil {
 		return minio.PartInfo{}, convertMultipartError(err, bucket, object, uploadID)
 	}
 
-	// TODO: Store the part's ETag in metabase
+	etag := etagSource()
+	lastModified := time.Now()
+
+	meta.Parts = upsertPart(meta.Parts, PartRecord{
+		PartNumber:   partID,
+		Size:         partInfo.Size,
+		ETag:         etag,
+		LastModified: lastModified,
+		SSENonce:     sseNonce,
+	})
+	if err := store.writeMeta(ctx, bucket, uploadID, meta); err != nil {
+		return minio.PartInfo{}, err
+	}
 
 	return minio.PartInfo{
-		PartNumber: partID,
-		Size:       partInfo.Size,
-		ETag:       data.MD5CurrentHexString(),
+		PartNumber:   partID,
+		Size:         partInfo.Size,
+		ETag:         etag,
+		LastModified: lastModified,
 	}, nil
 }
 
@@ -101,6 +225,9 @@ func (layer *gatewayLayer) AbortMultipartUpload(ctx context.Context, bucket, obj
 	if err != nil {
 		return convertMultipartError(err, bucket, object, uploadID)
 	}
+
+	deleteUploadPartsBestEffort(ctx, newObjectPartStore(project), bucket, object, uploadID)
+
 	return nil
 }
 
@@ -115,7 +242,24 @@ func (layer *gatewayLayer) CompleteMultipartUpload(ctx context.Context, bucket,
 		err = errs.Combine(err, project.Close())
 	}()
 
-	// TODO: Check that ETag of uploadedParts match the ETags stored in metabase.
+	store := newObjectPartStore(project)
+
+	storedParts, err := store.ListParts(ctx, bucket, object, uploadID)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	if err := validateUploadedParts(uploadedParts, storedParts); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	// SSE-C headers are only presented on InitiateMultipartUpload and
+	// UploadPart, never on CompleteMultipartUpload, so the upload's SSE
+	// state is read here purely to carry its metadata onto the completed
+	// object, not to re-validate a key against it.
+	sse, err := store.GetUploadSSE(ctx, bucket, object, uploadID)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
 
 	etag, err := multipartUploadETag(uploadedParts)
 	if err != nil {
@@ -124,6 +268,11 @@ func (layer *gatewayLayer) CompleteMultipartUpload(ctx context.Context, bucket,
 
 	metadata := uplink.CustomMetadata(opts.UserDefined).Clone()
 	metadata["s3:etag"] = etag
+	if sse != nil {
+		for k, v := range sseMetadata(*sse) {
+			metadata[k] = v
+		}
+	}
 
 	obj, err := multipart.CompleteMultipartUpload(ctx, project, bucket, object, uploadID, &multipart.ObjectOptions{
 		CustomMetadata: metadata,
@@ -132,9 +281,36 @@ func (layer *gatewayLayer) CompleteMultipartUpload(ctx context.Context, bucket,
 		return minio.ObjectInfo{}, convertMultipartError(err, bucket, object, uploadID)
 	}
 
+	deleteUploadPartsBestEffort(ctx, store, bucket, object, uploadID)
+
 	return minioObjectInfo(bucket, etag, obj), nil
 }
 
+// validateUploadedParts checks that the ETag the client claims for each
+// uploaded part matches the ETag recorded when the part was actually
+// uploaded, and that the parts were supplied in increasing part number
+// order, matching the semantics S3 clients expect from CompleteMultipartUpload.
+func validateUploadedParts(uploadedParts []minio.CompletePart, storedParts []PartRecord) error {
+	etagByPartNumber := make(map[int]string, len(storedParts))
+	for _, part := range storedParts {
+		etagByPartNumber[part.PartNumber] = part.ETag
+	}
+
+	lastPartNumber := 0
+	for _, part := range uploadedParts {
+		if part.PartNumber <= lastPartNumber {
+			return minio.InvalidPartOrder{PartNumber: part.PartNumber}
+		}
+		lastPartNumber = part.PartNumber
+
+		etag, ok := etagByPartNumber[part.PartNumber]
+		if !ok || canonicalEtag(etag) != canonicalEtag(part.ETag) {
+			return minio.InvalidPart{PartNumber: part.PartNumber}
+		}
+	}
+	return nil
+}
+
 func (layer *gatewayLayer) ListObjectParts(ctx context.Context, bucket, object, uploadID string, partNumberMarker int, maxParts int, opts minio.ObjectOptions) (result minio.ListPartsInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
@@ -151,26 +327,41 @@ func (layer *gatewayLayer) ListObjectParts(ctx context.Context, bucket, object,
 		return minio.ListPartsInfo{}, convertMultipartError(err, bucket, object, uploadID)
 	}
 
+	storedParts, err := newObjectPartStore(project).ListParts(ctx, bucket, object, uploadID)
+	if err != nil {
+		return minio.ListPartsInfo{}, err
+	}
+	etagByPartNumber := make(map[int]string, len(storedParts))
+	for _, part := range storedParts {
+		etagByPartNumber[part.PartNumber] = part.ETag
+	}
+
 	parts := make([]minio.PartInfo, 0, len(list.Items))
 	for _, item := range list.Items {
 		parts = append(parts, minio.PartInfo{
 			PartNumber:   item.PartNumber + 1,
 			LastModified: item.LastModified,
-			ETag:         "",        // TODO: Entity tag returned when the part was initially uploaded.
-			Size:         item.Size, // Size in bytes of the part.
-			ActualSize:   item.Size, // Decompressed Size.
+			ETag:         etagByPartNumber[item.PartNumber+1], // Entity tag returned when the part was initially uploaded.
+			Size:         item.Size,                           // Size in bytes of the part.
+			ActualSize:   item.Size,                           // Decompressed Size.
 		})
 	}
 	sort.Slice(parts, func(i, k int) bool {
 		return parts[i].PartNumber < parts[k].PartNumber
 	})
+
+	nextPartNumberMarker := partNumberMarker
+	if list.More && len(parts) > 0 {
+		nextPartNumberMarker = parts[len(parts)-1].PartNumber
+	}
+
 	return minio.ListPartsInfo{
 		Bucket:               bucket,
 		Object:               object,
 		UploadID:             uploadID,
 		StorageClass:         "",               // TODO
 		PartNumberMarker:     partNumberMarker, // Part number after which listing begins.
-		NextPartNumberMarker: partNumberMarker, // TODO Next part number marker to be used if list is truncated
+		NextPartNumberMarker: nextPartNumberMarker,
 		MaxParts:             maxParts,
 		IsTruncated:          list.More,
 		Parts:                parts,
@@ -0,0 +1,90 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"testing"
+
+	minio "github.com/storj/minio/cmd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateUploadedPartsAccepts(t *testing.T) {
+	storedParts := []PartRecord{
+		{PartNumber: 1, ETag: "etag-1"},
+		{PartNumber: 2, ETag: "etag-2"},
+	}
+	uploadedParts := []minio.CompletePart{
+		{PartNumber: 1, ETag: "etag-1"},
+		{PartNumber: 2, ETag: "etag-2"},
+	}
+
+	require.NoError(t, validateUploadedParts(uploadedParts, storedParts))
+}
+
+func TestValidateUploadedPartsRejectsETagMismatch(t *testing.T) {
+	storedParts := []PartRecord{
+		{PartNumber: 1, ETag: "etag-1"},
+	}
+	uploadedParts := []minio.CompletePart{
+		{PartNumber: 1, ETag: "not-the-etag-it-was-uploaded-with"},
+	}
+
+	err := validateUploadedParts(uploadedParts, storedParts)
+	require.Error(t, err)
+	require.IsType(t, minio.InvalidPart{}, err)
+}
+
+func TestValidateUploadedPartsRejectsUnknownPart(t *testing.T) {
+	storedParts := []PartRecord{
+		{PartNumber: 1, ETag: "etag-1"},
+	}
+	uploadedParts := []minio.CompletePart{
+		{PartNumber: 2, ETag: "etag-2"},
+	}
+
+	err := validateUploadedParts(uploadedParts, storedParts)
+	require.Error(t, err)
+	require.IsType(t, minio.InvalidPart{}, err)
+}
+
+func TestValidateUploadedPartsRejectsOutOfOrder(t *testing.T) {
+	storedParts := []PartRecord{
+		{PartNumber: 1, ETag: "etag-1"},
+		{PartNumber: 2, ETag: "etag-2"},
+	}
+	uploadedParts := []minio.CompletePart{
+		{PartNumber: 2, ETag: "etag-2"},
+		{PartNumber: 1, ETag: "etag-1"},
+	}
+
+	err := validateUploadedParts(uploadedParts, storedParts)
+	require.Error(t, err)
+	require.IsType(t, minio.InvalidPartOrder{}, err)
+}
+
+func TestValidateUploadedPartsRejectsRepeatedPartNumber(t *testing.T) {
+	storedParts := []PartRecord{
+		{PartNumber: 1, ETag: "etag-1"},
+	}
+	uploadedParts := []minio.CompletePart{
+		{PartNumber: 1, ETag: "etag-1"},
+		{PartNumber: 1, ETag: "etag-1"},
+	}
+
+	err := validateUploadedParts(uploadedParts, storedParts)
+	require.Error(t, err)
+	require.IsType(t, minio.InvalidPartOrder{}, err)
+}
+
+func TestValidateUploadedPartsIgnoresETagQuotingAndMultipartSuffix(t *testing.T) {
+	storedParts := []PartRecord{
+		{PartNumber: 1, ETag: "abc123-2"},
+	}
+	uploadedParts := []minio.CompletePart{
+		{PartNumber: 1, ETag: `"abc123"`},
+	}
+
+	require.NoError(t, validateUploadedParts(uploadedParts, storedParts))
+}
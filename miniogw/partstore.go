@@ -0,0 +1,379 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// multipartMetaBucket is the gateway-internal bucket PartStore persists its
+// per-upload metadata objects in, never the tenant's own bucket, analogous
+// to the way the MinIO FS backend keeps uploads.json in its .minio.sys
+// system bucket rather than next to the tenant's objects. Keeping it out of
+// the tenant's bucket keeps it out of their ListObjects/ListObjectsV2
+// results, off their storage usage, and safe from a client operation
+// (e.g. a recursive delete) that happens to touch its key.
+const multipartMetaBucket = ".gateway-mt-multipart"
+
+// multipartMetaPrefix is the key prefix PartStore persists per-upload part
+// metadata under within multipartMetaBucket.
+const multipartMetaPrefix = "meta/"
+
+// PartRecord is the metadata PartStore keeps for a single uploaded part.
+type PartRecord struct {
+	PartNumber   int
+	Size         int64
+	ETag         string
+	LastModified time.Time
+
+	// SSENonce is the random nonce material the part was encrypted with,
+	// when the upload has SSE-C/SSE-S3 state. It must be folded into the
+	// part's stream cipher nonce (see derivePartNonce in sse.go) so a
+	// re-uploaded part number never reuses a keystream.
+	SSENonce []byte
+}
+
+// PartStore persists per-part metadata (ETags, sizes, and timestamps) and
+// the SSE-C/SSE-S3 state for an in-progress multipart upload, keyed by
+// bucket, object, and upload ID. The satellite has no first-class
+// part-metadata table, so this fills the gap that CompleteMultipartUpload
+// and ListObjectParts otherwise can't: knowing the ETag a part was uploaded
+// with, so it can be returned to the client and validated against what the
+// client claims at completion time.
+type PartStore interface {
+	// PutPart records metadata for a part, replacing any existing record
+	// for the same part number.
+	PutPart(ctx context.Context, bucket, object, uploadID string, part PartRecord) error
+
+	// ListParts returns the recorded parts for an upload, ordered by part
+	// number.
+	ListParts(ctx context.Context, bucket, object, uploadID string) ([]PartRecord, error)
+
+	// DeleteParts removes every recorded part and SSE state for an upload.
+	// It is not an error if no records exist.
+	DeleteParts(ctx context.Context, bucket, object, uploadID string) error
+
+	// PutUploadSSE records the SSE-C/SSE-S3 state an upload was created
+	// with, so later parts can be validated against it.
+	PutUploadSSE(ctx context.Context, bucket, object, uploadID string, sse sseState) error
+
+	// GetUploadSSE returns the SSE state recorded for an upload, or nil if
+	// the upload was not created with server-side encryption.
+	GetUploadSSE(ctx context.Context, bucket, object, uploadID string) (*sseState, error)
+}
+
+// uploadMeta is everything PartStore persists for a single multipart
+// upload.
+type uploadMeta struct {
+	Parts []PartRecord
+	SSE   *sseState
+}
+
+// memPartStore is an in-memory PartStore, used in tests in place of the
+// object-backed implementation.
+type memPartStore struct {
+	mu      sync.Mutex
+	uploads map[string]*uploadMeta
+}
+
+// newMemPartStore constructs a memPartStore.
+func newMemPartStore() *memPartStore {
+	return &memPartStore{uploads: make(map[string]*uploadMeta)}
+}
+
+func partStoreKey(bucket, object, uploadID string) string {
+	return bucket + "/" + object + "/" + uploadID
+}
+
+func (s *memPartStore) upload(key string) *uploadMeta {
+	u, ok := s.uploads[key]
+	if !ok {
+		u = &uploadMeta{}
+		s.uploads[key] = u
+	}
+	return u
+}
+
+func (s *memPartStore) PutPart(ctx context.Context, bucket, object, uploadID string, part PartRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.upload(partStoreKey(bucket, object, uploadID))
+	u.Parts = upsertPart(u.Parts, part)
+	return nil
+}
+
+func (s *memPartStore) ListParts(ctx context.Context, bucket, object, uploadID string) ([]PartRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := append([]PartRecord(nil), s.uploads[partStoreKey(bucket, object, uploadID)].getParts()...)
+	sort.Slice(parts, func(i, k int) bool { return parts[i].PartNumber < parts[k].PartNumber })
+	return parts, nil
+}
+
+func (s *memPartStore) DeleteParts(ctx context.Context, bucket, object, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.uploads, partStoreKey(bucket, object, uploadID))
+	return nil
+}
+
+func (s *memPartStore) PutUploadSSE(ctx context.Context, bucket, object, uploadID string, sse sseState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.upload(partStoreKey(bucket, object, uploadID)).SSE = &sse
+	return nil
+}
+
+func (s *memPartStore) GetUploadSSE(ctx context.Context, bucket, object, uploadID string) (*sseState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.uploads[partStoreKey(bucket, object, uploadID)].getSSE(), nil
+}
+
+func (u *uploadMeta) getParts() []PartRecord {
+	if u == nil {
+		return nil
+	}
+	return u.Parts
+}
+
+func (u *uploadMeta) getSSE() *sseState {
+	if u == nil {
+		return nil
+	}
+	return u.SSE
+}
+
+// deleteUploadPartsBestEffort deletes an upload's recorded part metadata,
+// counting but swallowing any error. It is used to clean up after an abort
+// or complete that has already succeeded, so a failure here - the metadata
+// object momentarily unreachable, a network blip - must not turn an
+// otherwise-successful call into a failure for the client. The object it
+// leaves behind is harmless: it lives in multipartMetaBucket, not the
+// tenant's bucket, so it is never user-visible.
+func deleteUploadPartsBestEffort(ctx context.Context, store PartStore, bucket, object, uploadID string) {
+	if err := store.DeleteParts(ctx, bucket, object, uploadID); err != nil {
+		mon.Counter("multipart_delete_parts_errors").Inc(1)
+	}
+}
+
+// upsertPart replaces the record for part.PartNumber if one exists, or
+// appends it otherwise.
+func upsertPart(parts []PartRecord, part PartRecord) []PartRecord {
+	for i := range parts {
+		if parts[i].PartNumber == part.PartNumber {
+			parts[i] = part
+			return parts
+		}
+	}
+	return append(parts, part)
+}
+
+// partStoreLocks serializes read-modify-write access to each upload's
+// metadata object, so concurrent PutObjectPart/CopyObjectPart calls for the
+// same upload (the common case with parallel-part-upload clients) can't
+// both read the same stale uploadMeta and have the later write clobber the
+// earlier one. The MinIO FS backend this package is modeled on gets the
+// same guarantee from its lock manager around uploads.json.
+var partStoreLocks keyedMutex
+
+// keyedMutex is a set of mutexes, one per key, created lazily and dropped
+// once nothing holds or is waiting on them.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refcountedMutex
+}
+
+type refcountedMutex struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Lock blocks until the mutex for key is held.
+func (k *keyedMutex) Lock(key string) {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*refcountedMutex)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &refcountedMutex{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.mu.Lock()
+}
+
+// Unlock releases the mutex for key. It must be called exactly once for
+// every call to Lock with the same key.
+func (k *keyedMutex) Unlock(key string) {
+	k.mu.Lock()
+	l := k.locks[key]
+	l.refs--
+	if l.refs == 0 {
+		delete(k.locks, key)
+	}
+	k.mu.Unlock()
+
+	l.mu.Unlock()
+}
+
+// objectPartStore is a PartStore that persists its records as a single
+// JSON-encoded object written into multipartMetaBucket, the gateway's own
+// reserved bucket, the same way the MinIO FS backend keeps uploads.json in
+// its .minio.sys system bucket rather than next to the tenant's objects.
+type objectPartStore struct {
+	project *uplink.Project
+}
+
+// newObjectPartStore constructs an objectPartStore backed by project.
+func newObjectPartStore(project *uplink.Project) *objectPartStore {
+	return &objectPartStore{project: project}
+}
+
+// multipartMetaKey returns the key an upload's metadata object is stored
+// under within multipartMetaBucket. bucket is the tenant's bucket the
+// upload targets, included so uploads to different tenant buckets never
+// collide now that every upload's metadata lives in one shared bucket.
+func multipartMetaKey(bucket, uploadID string) string {
+	return multipartMetaPrefix + bucket + "/" + uploadID
+}
+
+// lock acquires the per-upload lock serializing reads and writes of the
+// upload's metadata object, returning a function that releases it. Callers
+// that need to read the metadata, act on it, and write it back (PutPart,
+// PutUploadSSE, and PutObjectPart's combined SSE-read-then-part-write) must
+// hold this lock for the whole sequence.
+func (s *objectPartStore) lock(bucket, uploadID string) (unlock func()) {
+	key := bucket + "/" + uploadID
+	partStoreLocks.Lock(key)
+	return func() { partStoreLocks.Unlock(key) }
+}
+
+func (s *objectPartStore) readMeta(ctx context.Context, bucket, uploadID string) (*uploadMeta, error) {
+	download, err := s.project.DownloadObject(ctx, multipartMetaBucket, multipartMetaKey(bucket, uploadID), nil)
+	if err != nil {
+		// A not-yet-created multipartMetaBucket (no upload has ever written
+		// to it) looks the same as a not-yet-written upload: no metadata
+		// recorded for this upload.
+		if errors.Is(err, uplink.ErrObjectNotFound) || errors.Is(err, uplink.ErrBucketNotFound) {
+			return &uploadMeta{}, nil
+		}
+		return nil, errs.Wrap(err)
+	}
+	defer func() { _ = download.Close() }()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(download); err != nil {
+		return nil, errs.Wrap(err)
+	}
+
+	meta := &uploadMeta{}
+	if buf.Len() > 0 {
+		if err := json.Unmarshal(buf.Bytes(), meta); err != nil {
+			return nil, errs.Wrap(err)
+		}
+	}
+	return meta, nil
+}
+
+func (s *objectPartStore) writeMeta(ctx context.Context, bucket, uploadID string, meta *uploadMeta) (err error) {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+
+	upload, err := s.project.UploadObject(ctx, multipartMetaBucket, multipartMetaKey(bucket, uploadID), nil)
+	if errors.Is(err, uplink.ErrBucketNotFound) {
+		// multipartMetaBucket is created lazily, the first time anything is
+		// ever written to it, rather than paying for an EnsureBucket
+		// round-trip on every part write for the common case where it
+		// already exists.
+		if _, ensureErr := s.project.EnsureBucket(ctx, multipartMetaBucket); ensureErr != nil {
+			return errs.Wrap(ensureErr)
+		}
+		upload, err = s.project.UploadObject(ctx, multipartMetaBucket, multipartMetaKey(bucket, uploadID), nil)
+	}
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			err = errs.Combine(err, upload.Abort())
+		}
+	}()
+
+	if _, err = upload.Write(data); err != nil {
+		return errs.Wrap(err)
+	}
+	if err = upload.Commit(); err != nil {
+		return errs.Wrap(err)
+	}
+	return nil
+}
+
+func (s *objectPartStore) PutPart(ctx context.Context, bucket, object, uploadID string, part PartRecord) error {
+	defer s.lock(bucket, uploadID)()
+
+	meta, err := s.readMeta(ctx, bucket, uploadID)
+	if err != nil {
+		return err
+	}
+	meta.Parts = upsertPart(meta.Parts, part)
+	return s.writeMeta(ctx, bucket, uploadID, meta)
+}
+
+func (s *objectPartStore) ListParts(ctx context.Context, bucket, object, uploadID string) ([]PartRecord, error) {
+	meta, err := s.readMeta(ctx, bucket, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	parts := meta.Parts
+	sort.Slice(parts, func(i, k int) bool { return parts[i].PartNumber < parts[k].PartNumber })
+	return parts, nil
+}
+
+func (s *objectPartStore) DeleteParts(ctx context.Context, bucket, object, uploadID string) error {
+	err := s.project.DeleteObject(ctx, multipartMetaBucket, multipartMetaKey(bucket, uploadID))
+	if err != nil && !errors.Is(err, uplink.ErrObjectNotFound) && !errors.Is(err, uplink.ErrBucketNotFound) {
+		return errs.Wrap(err)
+	}
+	return nil
+}
+
+func (s *objectPartStore) PutUploadSSE(ctx context.Context, bucket, object, uploadID string, sse sseState) error {
+	defer s.lock(bucket, uploadID)()
+
+	meta, err := s.readMeta(ctx, bucket, uploadID)
+	if err != nil {
+		return err
+	}
+	meta.SSE = &sse
+	return s.writeMeta(ctx, bucket, uploadID, meta)
+}
+
+func (s *objectPartStore) GetUploadSSE(ctx context.Context, bucket, object, uploadID string) (*sseState, error) {
+	meta, err := s.readMeta(ctx, bucket, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	return meta.SSE, nil
+}
@@ -0,0 +1,318 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"bytes"
+	"crypto/md5" /* #nosec G501 */ // Only used to validate the customer-supplied SSE-C key against its MD5 header.
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+
+	"github.com/storj/minio/cmd/crypto"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/errs"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// SSEConfig configures SSE-C and SSE-S3 support.
+type SSEConfig struct {
+	MasterKey string `help:"hex-encoded 32-byte master key SSE-S3 per-object keys are sealed with, loaded from KMS in production; empty disables SSE-S3" default:""`
+
+	// Enabled gates SSE-C and SSE-S3 on multipart uploads; PutObject does
+	// not read the SSE headers. GetObject/HeadObject do not decrypt what
+	// this package encrypts, so with this off, a multipart upload that
+	// requests SSE is rejected instead of stored unreadable.
+	Enabled bool `help:"enable SSE-C/SSE-S3 support; do not enable until GetObject/HeadObject decrypt what this writes" default:"false"`
+}
+
+func (c SSEConfig) masterKey() (key [32]byte, ok bool, err error) {
+	if c.MasterKey == "" {
+		return key, false, nil
+	}
+
+	raw, err := hex.DecodeString(c.MasterKey)
+	if err != nil || len(raw) != len(key) {
+		return key, false, errs.New("sse.master-key must be %d hex-encoded bytes", len(key))
+	}
+	copy(key[:], raw)
+	return key, true, nil
+}
+
+// sseConfig is the gateway's configured SSE-S3 master key, set once at
+// startup via SetSSEConfig.
+var sseConfig SSEConfig
+
+// SetSSEConfig installs the gateway's SSE-S3 configuration. It must be
+// called before any SSE-S3 uploads are handled.
+func SetSSEConfig(config SSEConfig) {
+	sseConfig = config
+}
+
+// SSE header names, analogous to MinIO's internal/crypto package.
+const (
+	sseCustomerAlgorithmHeader = "x-amz-server-side-encryption-customer-algorithm"
+	sseCustomerKeyHeader       = "x-amz-server-side-encryption-customer-key"
+	sseCustomerKeyMD5Header    = "x-amz-server-side-encryption-customer-key-md5"
+	sseHeader                  = "x-amz-server-side-encryption"
+
+	sseAlgorithmAES256 = "AES256"
+
+	// Metadata keys the sealed SSE state is stored under on the completed
+	// object, so GetObject/HeadObject can reconstruct and decrypt it once
+	// they're wired to do so. Until then, SSEConfig.Enabled keeps this
+	// metadata from ever being written for a client that can't get its
+	// plaintext back.
+	metaSSEAlgorithm = "s3:sse:algorithm"
+	metaSSESalt      = "s3:sse:salt"
+	metaSSEIV        = "s3:sse:iv"
+	metaSSESealedKey = "s3:sse:sealed-key"
+	metaSSEKeyMD5    = "s3:sse:key-md5"
+)
+
+// sseCheckValue is sealed under the upload's effective data key when an
+// upload is created, so later parts can verify a client presented the same
+// key without ever persisting the key itself.
+var sseCheckValue = []byte("storj.io/gateway-mt sse check value")
+
+// sseState is the server-side encryption configuration for a single
+// multipart upload, persisted alongside its parts so every PutObjectPart
+// call can validate against it and CompleteMultipartUpload can copy it onto
+// the completed object's metadata.
+type sseState struct {
+	Algorithm string // Always "AES256" today, for SSE-C and SSE-S3 alike.
+	Salt      []byte // SSE-S3 only: salt the per-object key was derived from the master key with.
+	IV        []byte // Nonce sseCheckValue was sealed under the effective key with.
+	SealedKey []byte // sseCheckValue sealed under the effective per-object key.
+	KeyMD5    string // Customer-supplied key MD5 (SSE-C only).
+}
+
+// customerKey is an SSE-C key as presented by a client on a request.
+type customerKey struct {
+	Key    [32]byte
+	KeyMD5 string
+}
+
+// parseCustomerKey extracts and validates the SSE-C headers on a request,
+// returning nil if none are present.
+func parseCustomerKey(userDefined map[string]string) (*customerKey, error) {
+	algorithm := userDefined[sseCustomerAlgorithmHeader]
+	keyB64 := userDefined[sseCustomerKeyHeader]
+	keyMD5 := userDefined[sseCustomerKeyMD5Header]
+
+	if algorithm == "" && keyB64 == "" && keyMD5 == "" {
+		return nil, nil
+	}
+	if algorithm != sseAlgorithmAES256 || keyB64 == "" || keyMD5 == "" {
+		return nil, crypto.ErrInvalidCustomerKey
+	}
+
+	key, err := base64.StdEncoding.DecodeString(keyB64)
+	if err != nil || len(key) != 32 {
+		return nil, crypto.ErrInvalidCustomerKey
+	}
+
+	/* #nosec G401 */
+	sum := md5.Sum(key)
+	if base64.StdEncoding.EncodeToString(sum[:]) != keyMD5 {
+		return nil, crypto.ErrCustomerKeyMD5Mismatch
+	}
+
+	out := &customerKey{KeyMD5: keyMD5}
+	copy(out.Key[:], key)
+	return out, nil
+}
+
+// isSSES3Requested reports whether SSE-S3 (server-managed keys) was
+// requested. It returns an error if the header is present but names an
+// algorithm other than AES256, rather than silently leaving the object
+// unencrypted.
+func isSSES3Requested(userDefined map[string]string) (bool, error) {
+	switch userDefined[sseHeader] {
+	case "":
+		return false, nil
+	case sseAlgorithmAES256:
+		return true, nil
+	default:
+		return false, crypto.ErrInvalidSSEParameters
+	}
+}
+
+// sealCheckValue seals sseCheckValue under key, returning the nonce and
+// sealed value to persist.
+func sealCheckValue(key [32]byte) (iv, sealedKey []byte, err error) {
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iv = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, err
+	}
+
+	return iv, aead.Seal(nil, iv, sseCheckValue, nil), nil
+}
+
+// verifyCheckValue returns crypto.ErrCustomerKeyMD5Mismatch unless key is
+// the key sseCheckValue was sealed under.
+func verifyCheckValue(key [32]byte, iv, sealedKey []byte) error {
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return err
+	}
+
+	opened, err := aead.Open(nil, iv, sealedKey, nil)
+	if err != nil || !bytes.Equal(opened, sseCheckValue) {
+		return crypto.ErrCustomerKeyMD5Mismatch
+	}
+	return nil
+}
+
+// deriveSSES3Key derives a fresh per-object data key for SSE-S3 from
+// masterKey and a random salt, returning the salt alongside the key so the
+// same key can be re-derived later from the master key and stored salt.
+func deriveSSES3Key(masterKey [32]byte) (salt []byte, key [32]byte, err error) {
+	salt = make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, key, err
+	}
+
+	h := blake3.NewDeriveKey("storj.io/gateway-mt sse-s3 object key")
+	if _, err := h.Write(masterKey[:]); err != nil {
+		return nil, key, err
+	}
+	if _, err := h.Write(salt); err != nil {
+		return nil, key, err
+	}
+	if _, err := h.Digest().Read(key[:]); err != nil {
+		return nil, key, err
+	}
+	return salt, key, nil
+}
+
+// resolvePartKey returns the effective per-upload data key for sse, given
+// the headers presented on a specific part request, validating that an
+// SSE-C request supplies the same key the upload was created with.
+func resolvePartKey(sse sseState, userDefined map[string]string) (key [32]byte, err error) {
+	if sse.KeyMD5 != "" {
+		partKey, err := parseCustomerKey(userDefined)
+		if err != nil {
+			return key, err
+		}
+		if partKey == nil || partKey.KeyMD5 != sse.KeyMD5 {
+			return key, crypto.ErrCustomerKeyMD5Mismatch
+		}
+		if err := verifyCheckValue(partKey.Key, sse.IV, sse.SealedKey); err != nil {
+			return key, err
+		}
+		return partKey.Key, nil
+	}
+
+	// SSE-S3: the key is never presented by the client, so it's always
+	// re-derived from the configured master key and the upload's stored
+	// salt.
+	masterKey, ok, err := sseConfig.masterKey()
+	if err != nil {
+		return key, err
+	}
+	if !ok {
+		return key, crypto.ErrInvalidSSEParameters
+	}
+
+	h := blake3.NewDeriveKey("storj.io/gateway-mt sse-s3 object key")
+	if _, err := h.Write(masterKey[:]); err != nil {
+		return key, err
+	}
+	if _, err := h.Write(sse.Salt); err != nil {
+		return key, err
+	}
+	if _, err := h.Digest().Read(key[:]); err != nil {
+		return key, err
+	}
+	return key, nil
+}
+
+// partNonceSize is the size of the random nonce material generated fresh
+// for every PutObjectPart/CopyObjectPart call. S3 allows re-uploading a
+// part number (client retries, CopyObjectPart targeting the same part
+// again), and deriving a part's stream cipher nonce from only the upload's
+// static IV and part number would reuse the same (key, nonce) pair against
+// different plaintext on a re-upload, breaking chacha20's confidentiality.
+// Folding in fresh randomness per call closes that.
+const partNonceSize = 24
+
+// newPartNonce returns fresh random nonce material for one
+// PutObjectPart/CopyObjectPart call, to be persisted in the part's
+// PartRecord and fed into derivePartNonce both now and whenever the part
+// needs to be decrypted later.
+func newPartNonce() ([]byte, error) {
+	nonce := make([]byte, partNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// derivePartNonce derives a part's stream cipher nonce from an upload's IV,
+// the part's own random nonce material, and its part number, so no two
+// calls - concurrent parts, or the same part number re-uploaded - ever
+// reuse a keystream even though every part shares one data key.
+func derivePartNonce(iv, partNonce []byte, partID int) [chacha20.NonceSizeX]byte {
+	h := blake3.New()
+	_, _ = h.Write(iv)
+	_, _ = h.Write(partNonce)
+	_, _ = h.Write([]byte{byte(partID >> 24), byte(partID >> 16), byte(partID >> 8), byte(partID)})
+
+	var nonce [chacha20.NonceSizeX]byte
+	_, _ = h.Digest().Read(nonce[:])
+	return nonce
+}
+
+// sseReader XOR-encrypts (or decrypts; the cipher is symmetric) everything
+// read from the wrapped reader with a per-part keystream derived from an
+// upload's effective data key and IV.
+type sseReader struct {
+	r      io.Reader
+	cipher *chacha20.Cipher
+}
+
+// newSSEReader wraps r so that each byte read from it is encrypted under
+// key with a nonce unique to this part and this specific upload call (see
+// partNonceSize).
+func newSSEReader(key [32]byte, iv, partNonce []byte, partID int, r io.Reader) (io.Reader, error) {
+	nonce := derivePartNonce(iv, partNonce, partID)
+	c, err := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	if err != nil {
+		return nil, err
+	}
+	return &sseReader{r: r, cipher: c}, nil
+}
+
+func (r *sseReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.cipher.XORKeyStream(p[:n], p[:n])
+	}
+	return n, err
+}
+
+// sseMetadata returns the s3:sse:* CustomMetadata entries that record sse
+// on the completed object.
+func sseMetadata(sse sseState) map[string]string {
+	meta := map[string]string{
+		metaSSEAlgorithm: sse.Algorithm,
+		metaSSEIV:        base64.StdEncoding.EncodeToString(sse.IV),
+		metaSSESealedKey: base64.StdEncoding.EncodeToString(sse.SealedKey),
+	}
+	if sse.KeyMD5 != "" {
+		meta[metaSSEKeyMD5] = sse.KeyMD5
+	}
+	if len(sse.Salt) > 0 {
+		meta[metaSSESalt] = base64.StdEncoding.EncodeToString(sse.Salt)
+	}
+	return meta
+}